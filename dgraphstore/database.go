@@ -21,14 +21,169 @@ var errPathMissing = errors.New("gRPC url is required")
 type Database struct {
 	Service *dgo.Dgraph
 	conn    *grpc.ClientConn
+	// timeout, when greater than zero, bounds every operation started from a
+	// non-Ctx method or from a caller-provided context, see `WithTimeout`.
+	timeout time.Duration
 	logger  *golog.Logger
 }
 
+// Option configures a Database created by `New` or `NewFromDB`.
+type Option func(*Database)
+
+// WithTimeout makes every operation of this Database time out after "d" if
+// it hasn't completed by then, including operations started through the
+// non-Ctx methods or with a caller context that has no deadline of its own.
+// It lets a deployment enforce a per-op deadline globally without threading
+// a context through every session call site.
+func WithTimeout(d time.Duration) Option {
+	return func(db *Database) {
+		db.timeout = d
+	}
+}
+
+// SessionEntry is the typed representation of a session key-value pair
+// stored as a Dgraph "SessionEntry" node. Mutations are built by marshalling
+// this struct to JSON instead of hand-concatenating N-Quad strings, so sid
+// and key values that contain quotes or DQL syntax can never break out of
+// the payload they're embedded in.
+type SessionEntry struct {
+	UID   string `json:"uid,omitempty"`
+	Sid   string `json:"sid"`
+	Key   string `json:"skey"`
+	Value string `json:"svalue,omitempty"`
+	Type  string `json:"dgraph.type,omitempty"`
+}
+
+// Query templates used with `QueryWithVars`/`Request.Vars` so that sid and
+// key values are always sent as DQL variables, never concatenated into the
+// query text.
+const (
+	queryAcquire = `query q($sid: string) {
+		q(func: eq(skey, $sid)) @filter(eq(sid, $sid)) {
+			svalue
+		}
+	}`
+
+	queryFindUID = `query q($sid: string, $key: string) {
+		q(func: eq(skey, $key)) @filter(eq(sid, $sid)) {
+			v as uid
+		}
+	}`
+
+	queryFindEntry = `query q($sid: string, $key: string) {
+		q(func: eq(skey, $key)) @filter(eq(sid, $sid)) {
+			uid
+			skey
+			svalue
+		}
+	}`
+
+	queryVisit = `query q($sid: string) {
+		q(func: eq(sid, $sid)) {
+			skey
+			svalue
+		}
+	}`
+
+	queryLen = `query q($sid: string) {
+		q(func: eq(sid, $sid)) {
+			count(uid)
+		}
+	}`
+
+	queryFindAllUID = `query q($sid: string) {
+		q(func: eq(sid, $sid)) {
+			v as uid
+		}
+	}`
+
+	queryClearEntries = `query q($sid: string) {
+		q(func: eq(sid, $sid)) {
+			uid
+			skey
+			svalue
+		}
+	}`
+)
+
+// sidVars and sidKeyVars build the `Request.Vars`/`QueryWithVars` maps that
+// carry sid and key to Dgraph, so that callers never need to (and production
+// code never does) concatenate them into a query string.
+func sidVars(sid string) map[string]string {
+	return map[string]string{"$sid": sid}
+}
+
+func sidKeyVars(sid, key string) map[string]string {
+	return map[string]string{"$sid": sid, "$key": key}
+}
+
+// acquireInsertRequest builds the request that creates a session's lifetime
+// entry the first time `Acquire` observes an unknown sid.
+func acquireInsertRequest(sid, timeBase string) (*api.Request, error) {
+	entryJSON, err := json.Marshal(SessionEntry{Sid: sid, Key: sid, Value: timeBase, Type: "SessionEntry"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Request{
+		Mutations: []*api.Mutation{{SetJson: entryJSON}},
+		CommitNow: true,
+	}, nil
+}
+
+// setRequest builds the upsert request `Set` issues: it binds the blank node
+// "v" to the existing (sid, key) entry, if any, via `queryFindUID`, then
+// sets its fields from a SetJson payload instead of hand-built N-Quads.
+func setRequest(sid, key, valueBase string) (*api.Request, error) {
+	entryJSON, err := json.Marshal(SessionEntry{UID: "uid(v)", Sid: sid, Key: key, Value: valueBase, Type: "SessionEntry"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Request{
+		Query: queryFindUID,
+		Vars:  sidKeyVars(sid, key),
+		Mutations: []*api.Mutation{
+			{SetJson: entryJSON},
+		},
+		CommitNow: true,
+	}, nil
+}
+
+// deleteEntryMutation builds the DeleteJson mutation that removes a single,
+// already-resolved entry (as looked up via `queryFindEntry`/`queryClearEntries`).
+func deleteEntryMutation(uid, sid, key, value string) (*api.Mutation, error) {
+	entryJSON, err := json.Marshal(SessionEntry{UID: uid, Sid: sid, Key: key, Value: value})
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Mutation{CommitNow: true, DeleteJson: entryJSON}, nil
+}
+
+// releaseRequest builds the request `Release` issues to wipe a whole session:
+// it binds the blank node "v" to every entry of "sid" via `queryFindAllUID`,
+// then deletes all predicates of each bound node. The deletion N-Quads embed
+// no caller-controlled data (only the "v" variable reference), so they need
+// no parameterization.
+func releaseRequest(sid string) *api.Request {
+	const deletion = `uid(v) * * . `
+
+	return &api.Request{
+		Query: queryFindAllUID,
+		Vars:  sidVars(sid),
+		Mutations: []*api.Mutation{
+			{DelNquads: []byte(deletion)},
+		},
+		CommitNow: true,
+	}
+}
+
 // New creates and returns a new Dgraph database connection to "target" with `grpc.WithInsecure()`.
 // Target should include the url to Dgraph's alpha gRPC-external-public port.
 //
 // It will remove any old session files.
-func New(target string) (*Database, error) {
+func New(target string, opts ...Option) (*Database, error) {
 	if target == "" {
 		return nil, errPathMissing
 	}
@@ -38,11 +193,11 @@ func New(target string) (*Database, error) {
 		return nil, err
 	}
 
-	return NewFromDB(conn)
+	return NewFromDB(conn, opts...)
 }
 
 // NewFromDB same as `New` but accepts an already-created secured gRPC connection instead.
-func NewFromDB(conn *grpc.ClientConn) (*Database, error) {
+func NewFromDB(conn *grpc.ClientConn, opts ...Option) (*Database, error) {
 	dc := api.NewDgraphClient(conn)
 	dg := dgo.NewDgraphClient(dc)
 
@@ -72,9 +227,9 @@ func NewFromDB(conn *grpc.ClientConn) (*Database, error) {
 	if len(r.Schema) == 0 {
 		op := &api.Operation{}
 		op.Schema = `
-	sid: string @index(hash) . 
-	skey: string @index(hash) . 
-	svalue: string . 
+	sid: string @index(hash) .
+	skey: string @index(hash) .
+	svalue: string .
 	type SessionEntry {
 		sid
 		skey
@@ -88,6 +243,10 @@ func NewFromDB(conn *grpc.ClientConn) (*Database, error) {
 	}
 
 	db := &Database{Service: dg, conn: conn}
+	for _, opt := range opts {
+		opt(db)
+	}
+
 	return db, nil
 }
 
@@ -97,22 +256,37 @@ func (db *Database) SetLogger(logger *golog.Logger) {
 	db.logger = logger
 }
 
+// withTimeout derives a context bound by the Database's configured timeout,
+// if any, from "parent". Callers must always invoke the returned cancel func.
+func (db *Database) withTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if db.timeout <= 0 {
+		return parent, func() {}
+	}
+	return context.WithTimeout(parent, db.timeout)
+}
+
 // Acquire receives a session's lifetime from the database,
 // if the return value is LifeTime{} then the session manager sets the life time based on the expiration duration lives in configuration.
 func (db *Database) Acquire(sid string, expires time.Duration) sessions.LifeTime {
-	ctx := context.Background()
+	return db.AcquireCtx(context.Background(), sid, expires)
+}
 
-	query := `{
-	q(func: eq(skey, "` + sid + `")) @filter(eq(sid, "` + sid + `")) {
-	  svalue
+// AcquireCtx is the context-aware variant of `Acquire`.
+func (db *Database) AcquireCtx(ctx context.Context, sid string, expires time.Duration) sessions.LifeTime {
+	if err := ctx.Err(); err != nil {
+		return sessions.LifeTime{}
 	}
-}`
 
-	response, _ := db.Service.NewTxn().Query(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	response, err := db.Service.NewTxn().QueryWithVars(ctx, queryAcquire, sidVars(sid))
+	if err != nil {
+		return sessions.LifeTime{}
+	}
 
 	var r struct {
 		Session []struct {
-			// Key   string `json:"key"`
 			Value string `json:"svalue"`
 		} `json:"q"`
 	}
@@ -121,29 +295,16 @@ func (db *Database) Acquire(sid string, expires time.Duration) sessions.LifeTime
 
 	// not found, create an entry and return an empty lifetime, session manager will do its job.
 	if len(r.Session) == 0 {
-		ctx := context.Background()
-
 		expirationTime := time.Now().Add(expires)
 		timeBytes, _ := sessions.DefaultTranscoder.Marshal(expirationTime)
 		timeBase := base64.StdEncoding.EncodeToString(timeBytes)
 
-		mutation := `
-		uid(v) <sid> "` + sid + `" .
-		uid(v) <skey> "` + sid + `" .
-		uid(v) <svalue> "` + timeBase + `" . 
-		uid(v) <dgraph.type> "SessionEntry" . 
-		`
-
-		req := &api.Request{
-			Mutations: []*api.Mutation{
-				{
-					SetNquads: []byte(mutation),
-				},
-			},
-			CommitNow: true,
+		req, err := acquireInsertRequest(sid, timeBase)
+		if err != nil {
+			return sessions.LifeTime{}
 		}
 
-		_, err := db.Service.NewTxn().Do(ctx, req)
+		_, err = db.Service.NewTxn().Do(ctx, req)
 		if err != nil {
 			return sessions.LifeTime{}
 		}
@@ -161,12 +322,26 @@ func (db *Database) Acquire(sid string, expires time.Duration) sessions.LifeTime
 // OnUpdateExpiration not implemented here, yet.
 // Note that this error will not be logged, callers should catch it manually.
 func (db *Database) OnUpdateExpiration(sid string, newExpires time.Duration) error {
+	return db.OnUpdateExpirationCtx(context.Background(), sid, newExpires)
+}
+
+// OnUpdateExpirationCtx is the context-aware variant of `OnUpdateExpiration`, not implemented here, yet.
+func (db *Database) OnUpdateExpirationCtx(ctx context.Context, sid string, newExpires time.Duration) error {
 	return sessions.ErrNotImplemented
 }
 
 // Set sets a key value of a specific session.
 // Ignore the "immutable".
 func (db *Database) Set(sid string, lifetime sessions.LifeTime, key string, value interface{}, immutable bool) {
+	db.SetCtx(context.Background(), sid, lifetime, key, value, immutable)
+}
+
+// SetCtx is the context-aware variant of `Set`.
+func (db *Database) SetCtx(ctx context.Context, sid string, lifetime sessions.LifeTime, key string, value interface{}, immutable bool) {
+	if err := ctx.Err(); err != nil {
+		return
+	}
+
 	valueBytes, err := sessions.DefaultTranscoder.Marshal(value)
 	if err != nil {
 		return
@@ -174,39 +349,26 @@ func (db *Database) Set(sid string, lifetime sessions.LifeTime, key string, valu
 
 	// convert []byte slice to base64 string
 	valueBase := base64.StdEncoding.EncodeToString(valueBytes)
-	ctx := context.Background()
-	query := `
-	{
-		  q(func: eq(skey, "` + key + `")) @filter(eq(sid, "` + sid + `"))  {
-			v as uid
-		  }
-	}
-`
 
-	mutation := `
-	uid(v) <sid> "` + sid + `" .
-	uid(v) <skey> "` + key + `" .
-	uid(v) <svalue> "` + valueBase + `" . 
-	uid(v) <dgraph.type> "SessionEntry" . 
-	`
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	req := &api.Request{
-		Query: query,
-		Mutations: []*api.Mutation{
-			{
-				SetNquads: []byte(mutation),
-			},
-		},
-		CommitNow: true,
+	req, err := setRequest(sid, key, valueBase)
+	if err != nil {
+		return
 	}
 
 	db.Service.NewTxn().Do(ctx, req)
-	return
 }
 
 // Get retrieves a session value based on the key.
 func (db *Database) Get(sid string, key string) (value interface{}) {
-	if err := db.Decode(sid, key, &value); err == nil {
+	return db.GetCtx(context.Background(), sid, key)
+}
+
+// GetCtx is the context-aware variant of `Get`.
+func (db *Database) GetCtx(ctx context.Context, sid string, key string) (value interface{}) {
+	if err := db.DecodeCtx(ctx, sid, key, &value); err == nil {
 		return value
 	}
 
@@ -215,22 +377,25 @@ func (db *Database) Get(sid string, key string) (value interface{}) {
 
 // Decode binds the "outPtr" to the value associated to the provided "key".
 func (db *Database) Decode(sid, key string, outPtr interface{}) error {
-	ctx := context.Background()
+	return db.DecodeCtx(context.Background(), sid, key, outPtr)
+}
 
-	query := `{
-	q(func: eq(skey, "` + key + `")) @filter(eq(sid, "` + sid + `")) {
-	  svalue
+// DecodeCtx is the context-aware variant of `Decode`.
+func (db *Database) DecodeCtx(ctx context.Context, sid, key string, outPtr interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-}`
 
-	response, err := db.Service.NewTxn().Query(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	response, err := db.Service.NewTxn().QueryWithVars(ctx, queryFindEntry, sidKeyVars(sid, key))
 	if err != nil {
 		return err
 	}
 
 	var r struct {
 		Session []struct {
-			// Key   string `json:"key"`
 			Value string `json:"svalue"`
 		} `json:"q"`
 	}
@@ -252,16 +417,19 @@ func (db *Database) Decode(sid, key string, outPtr interface{}) error {
 
 // Visit loops through all session keys and values.
 func (db *Database) Visit(sid string, cb func(key string, value interface{})) {
-	ctx := context.Background()
+	db.VisitCtx(context.Background(), sid, cb)
+}
 
-	query := `{
-	q(func: eq(sid, "` + sid + `")) {
-	  skey
-	  svalue
+// VisitCtx is the context-aware variant of `Visit`.
+func (db *Database) VisitCtx(ctx context.Context, sid string, cb func(key string, value interface{})) {
+	if err := ctx.Err(); err != nil {
+		return
 	}
-}`
 
-	response, err := db.Service.NewTxn().Query(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	response, err := db.Service.NewTxn().QueryWithVars(ctx, queryVisit, sidVars(sid))
 	if err != nil {
 		return
 	}
@@ -296,15 +464,19 @@ func (db *Database) Visit(sid string, cb func(key string, value interface{})) {
 
 // Len returns the length of the session's entries (keys).
 func (db *Database) Len(sid string) (n int) {
-	ctx := context.Background()
+	return db.LenCtx(context.Background(), sid)
+}
 
-	query := `{
-	q(func: eq(sid, "` + sid + `")) {
-	  count(uid)
+// LenCtx is the context-aware variant of `Len`.
+func (db *Database) LenCtx(ctx context.Context, sid string) (n int) {
+	if err := ctx.Err(); err != nil {
+		return 0
 	}
-}`
 
-	response, err := db.Service.NewTxn().Query(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	response, err := db.Service.NewTxn().QueryWithVars(ctx, queryLen, sidVars(sid))
 	if err != nil {
 		return
 	}
@@ -316,7 +488,7 @@ func (db *Database) Len(sid string) (n int) {
 	}
 
 	err = json.Unmarshal(response.Json, &r)
-	if err == nil {
+	if err == nil && len(r.Session) > 0 {
 		n = r.Session[0].TotalKeys
 		return
 	}
@@ -326,47 +498,60 @@ func (db *Database) Len(sid string) (n int) {
 
 // Delete removes a session key value based on its key.
 func (db *Database) Delete(sid string, key string) (deleted bool) {
-	ctx := context.Background()
+	return db.DeleteCtx(context.Background(), sid, key)
+}
 
-	query := `{
-		  q(func: eq(sid, "` + sid + `")) {
-			v as uid
-		  }
-}`
+// DeleteCtx is the context-aware variant of `Delete`.
+func (db *Database) DeleteCtx(ctx context.Context, sid string, key string) (deleted bool) {
+	if err := ctx.Err(); err != nil {
+		return false
+	}
 
-	deletion := `uid(v) "` + key + `" * . `
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	req := &api.Request{
-		Query: query,
-		Mutations: []*api.Mutation{
-			{
-				DelNquads: []byte(deletion),
-			},
-		},
-		CommitNow: true,
+	response, err := db.Service.NewTxn().QueryWithVars(ctx, queryFindEntry, sidKeyVars(sid, key))
+	if err != nil {
+		return false
 	}
 
-	_, err := db.Service.NewTxn().Do(ctx, req)
+	var r struct {
+		Session []struct {
+			UID   string `json:"uid"`
+			Key   string `json:"skey"`
+			Value string `json:"svalue"`
+		} `json:"q"`
+	}
+
+	if err := json.Unmarshal(response.Json, &r); err != nil || len(r.Session) == 0 {
+		return false
+	}
+
+	entry := r.Session[0]
+	mu, err := deleteEntryMutation(entry.UID, sid, entry.Key, entry.Value)
 	if err != nil {
 		return false
 	}
 
-	return true
+	_, err = db.Service.NewTxn().Mutate(ctx, mu)
+	return err == nil
 }
 
 // Clear removes all session key values but it keeps the session entry.
 func (db *Database) Clear(sid string) {
-	ctx := context.Background()
+	db.ClearCtx(context.Background(), sid)
+}
 
-	query := `{
-	q(func: eq(sid, "` + sid + `")) {
-	  uid
-	  skey
-	  svalue
+// ClearCtx is the context-aware variant of `Clear`.
+func (db *Database) ClearCtx(ctx context.Context, sid string) {
+	if err := ctx.Err(); err != nil {
+		return
 	}
-}`
 
-	response, err := db.Service.NewTxn().Query(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	response, err := db.Service.NewTxn().QueryWithVars(ctx, queryClearEntries, sidVars(sid))
 	if err != nil {
 		return
 	}
@@ -376,7 +561,6 @@ func (db *Database) Clear(sid string) {
 			UID   string `json:"uid"`
 			Key   string `json:"skey"`
 			Value string `json:"svalue"`
-			Sid   string `json:"sid"`
 		} `json:"q"`
 	}
 
@@ -391,12 +575,11 @@ func (db *Database) Clear(sid string) {
 			continue
 		}
 
-		entry.Sid = sid
-		del, _ := json.Marshal(entry)
-		mu := &api.Mutation{
-			CommitNow:  true,
-			DeleteJson: del,
+		mu, err := deleteEntryMutation(entry.UID, sid, entry.Key, entry.Value)
+		if err != nil {
+			continue
 		}
+
 		db.Service.NewTxn().Mutate(ctx, mu)
 	}
 }
@@ -404,30 +587,19 @@ func (db *Database) Clear(sid string) {
 // Release destroys the session, it clears and removes the session entry,
 // session manager will create a new session ID on the next request after this call.
 func (db *Database) Release(sid string) {
-	ctx := context.Background()
-
-	query := `{
-		  q(func: eq(sid, "` + sid + `")) {
-			v as uid
-		  }
-}`
-
-	deletion := `uid(v) * * . `
-
-	req := &api.Request{
-		Query: query,
-		Mutations: []*api.Mutation{
-			{
-				DelNquads: []byte(deletion),
-			},
-		},
-		CommitNow: true,
-	}
+	db.ReleaseCtx(context.Background(), sid)
+}
 
-	_, err := db.Service.NewTxn().Do(ctx, req)
-	if err != nil {
+// ReleaseCtx is the context-aware variant of `Release`.
+func (db *Database) ReleaseCtx(ctx context.Context, sid string) {
+	if err := ctx.Err(); err != nil {
 		return
 	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	db.Service.NewTxn().Do(ctx, releaseRequest(sid))
 }
 
 // Close terminates Dgraph's gRPC connection.
@@ -0,0 +1,149 @@
+package dgraphstore
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// maliciousKeys are sid/key values that would break out of a hand-built DQL
+// query or N-Quad string if they were ever concatenated into one, instead of
+// being routed through `Request.Vars`/`SetJson`.
+var maliciousKeys = []string{
+	`quote"injection`,
+	`back\slash`,
+	`") or eq(1, 1) @filter(eq(sid, "`,
+}
+
+// TestSidVarsCarryInputUnmodified proves `sidVars`/`sidKeyVars` - the vars
+// builders every *Ctx method calls before issuing a query - pass sid/key
+// through unmodified and keep the query templates themselves static.
+func TestSidVarsCarryInputUnmodified(t *testing.T) {
+	queries := map[string]string{
+		"queryAcquire":      queryAcquire,
+		"queryFindUID":      queryFindUID,
+		"queryFindEntry":    queryFindEntry,
+		"queryVisit":        queryVisit,
+		"queryLen":          queryLen,
+		"queryFindAllUID":   queryFindAllUID,
+		"queryClearEntries": queryClearEntries,
+	}
+
+	for _, malicious := range maliciousKeys {
+		vars := sidVars(malicious)
+		if vars["$sid"] != malicious {
+			t.Fatalf("sidVars(%q) = %q, want the raw input unmodified", malicious, vars["$sid"])
+		}
+
+		vars = sidKeyVars(malicious, malicious)
+		if vars["$sid"] != malicious || vars["$key"] != malicious {
+			t.Fatalf("sidKeyVars(%q, %q) did not carry both values unmodified: %+v", malicious, malicious, vars)
+		}
+
+		for name, q := range queries {
+			if strings.Contains(q, malicious) {
+				t.Fatalf("%s unexpectedly embeds attacker-controlled input: %q", name, q)
+			}
+		}
+	}
+}
+
+// TestAcquireInsertRequestEmbedsKeyViaJSON proves `acquireInsertRequest` -
+// the request `AcquireCtx` issues for a brand new sid - carries a malicious
+// sid through a marshalled SessionEntry instead of a hand-built mutation.
+func TestAcquireInsertRequestEmbedsKeyViaJSON(t *testing.T) {
+	for _, malicious := range maliciousKeys {
+		req, err := acquireInsertRequest(malicious, "time-base64")
+		if err != nil {
+			t.Fatalf("acquireInsertRequest(%q): %v", malicious, err)
+		}
+
+		if len(req.Mutations) != 1 {
+			t.Fatalf("acquireInsertRequest(%q) built %d mutations, want 1", malicious, len(req.Mutations))
+		}
+
+		var entry SessionEntry
+		if err := json.Unmarshal(req.Mutations[0].SetJson, &entry); err != nil {
+			t.Fatalf("unmarshal SetJson for %q: %v", malicious, err)
+		}
+
+		if entry.Sid != malicious || entry.Key != malicious {
+			t.Fatalf("acquireInsertRequest(%q) round-tripped Sid=%q Key=%q, want both to equal the input", malicious, entry.Sid, entry.Key)
+		}
+	}
+}
+
+// TestSetRequestRoutesThroughVarsAndJSON proves `setRequest` - the request
+// `SetCtx` issues - keeps its query template static and carries sid/key
+// through `Vars` and the marshalled SessionEntry, never the query text.
+func TestSetRequestRoutesThroughVarsAndJSON(t *testing.T) {
+	for _, malicious := range maliciousKeys {
+		req, err := setRequest(malicious, malicious, "value-base64")
+		if err != nil {
+			t.Fatalf("setRequest(%q, %q): %v", malicious, malicious, err)
+		}
+
+		if req.Query != queryFindUID {
+			t.Fatalf("setRequest changed the query template: %q", req.Query)
+		}
+
+		if req.Vars["$sid"] != malicious || req.Vars["$key"] != malicious {
+			t.Fatalf("setRequest(%q, %q) did not carry both values through Vars: %+v", malicious, malicious, req.Vars)
+		}
+
+		if len(req.Mutations) != 1 {
+			t.Fatalf("setRequest built %d mutations, want 1", len(req.Mutations))
+		}
+
+		var entry SessionEntry
+		if err := json.Unmarshal(req.Mutations[0].SetJson, &entry); err != nil {
+			t.Fatalf("unmarshal SetJson: %v", err)
+		}
+
+		if entry.Sid != malicious || entry.Key != malicious {
+			t.Fatalf("setRequest(%q, %q) round-tripped Sid=%q Key=%q", malicious, malicious, entry.Sid, entry.Key)
+		}
+	}
+}
+
+// TestDeleteEntryMutationEmbedsValuesViaJSON proves `deleteEntryMutation` -
+// used by both `DeleteCtx` and `ClearCtx` - carries the resolved uid/sid/key
+// into a DeleteJson payload rather than a hand-built N-Quad.
+func TestDeleteEntryMutationEmbedsValuesViaJSON(t *testing.T) {
+	for _, malicious := range maliciousKeys {
+		mu, err := deleteEntryMutation("0x1", malicious, malicious, "value")
+		if err != nil {
+			t.Fatalf("deleteEntryMutation(%q): %v", malicious, err)
+		}
+
+		var entry SessionEntry
+		if err := json.Unmarshal(mu.DeleteJson, &entry); err != nil {
+			t.Fatalf("unmarshal DeleteJson for %q: %v", malicious, err)
+		}
+
+		if entry.Sid != malicious || entry.Key != malicious {
+			t.Fatalf("deleteEntryMutation(%q) round-tripped Sid=%q Key=%q", malicious, entry.Sid, entry.Key)
+		}
+	}
+}
+
+// TestReleaseRequestRoutesSidThroughVars proves `releaseRequest` - used by
+// `ReleaseCtx` - keeps its query template static and carries sid through
+// `Vars` only; the N-Quad deletion itself references no caller input.
+func TestReleaseRequestRoutesSidThroughVars(t *testing.T) {
+	for _, malicious := range maliciousKeys {
+		req := releaseRequest(malicious)
+
+		if req.Query != queryFindAllUID {
+			t.Fatalf("releaseRequest changed the query template: %q", req.Query)
+		}
+
+		if req.Vars["$sid"] != malicious {
+			t.Fatalf("releaseRequest(%q) did not carry sid through Vars: %+v", malicious, req.Vars)
+		}
+
+		if len(req.Mutations) != 1 || strings.Contains(string(req.Mutations[0].DelNquads), malicious) {
+			t.Fatalf("releaseRequest(%q) leaked sid into the deletion N-Quads: %+v", malicious, req.Mutations)
+		}
+	}
+}
@@ -16,20 +16,75 @@ import (
 
 var errDatabaseNameMissing = errors.New("database name is required")
 
-// Database the BoltDB(file-based) session storage.
+// DefaultCollectionName is the collection name used to store all sessions
+// when no custom one is given to `New`.
+const DefaultCollectionName = "sessions"
+
+// lifetimeKey is the reserved "key" value used to keep track of a session's
+// expiration time, it never holds an actual caller-set value.
+const lifetimeKey = "$lifetime"
+
+// defaultEntryExpiration is the fallback TTL stamped on an entry written
+// through `Set` when the caller gave no duration and the session has no
+// lifetime entry yet to copy an expiration from.
+const defaultEntryExpiration = 24 * time.Hour
+
+// Database the MongoDB(file-based) session storage.
 type Database struct {
-	// mongo *mongo.Database
+	// Service is the underlying mongo database, exposed for advanced use cases.
 	Service *mongo.Database
+	// collection is the single collection all sessions of this Database live in.
+	collection *mongo.Collection
+	// timeout, when greater than zero, bounds every operation started from a
+	// non-Ctx method or from a caller-provided context, see `WithTimeout`.
+	timeout time.Duration
 	logger  *golog.Logger
 }
 
 var _ sessions.Database = (*Database)(nil)
 
-// New creates and returns a new MongoDB(file-based) storage with custom client options.
-// Database and collection names should be included.
+// sessionEntry represents a single session key-value pair stored in the
+// shared sessions collection. Every entry of the same session shares the
+// same "sid" and "expiresAt" so MongoDB's TTL monitor can reap the whole
+// session in one go once it expires.
+type sessionEntry struct {
+	Sid       string    `bson:"sid"`
+	Key       string    `bson:"key"`
+	Value     []byte    `bson:"value,omitempty"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// Option configures a Database created by `New`.
+type Option func(*Database)
+
+// WithCollection sets the collection name sessions are stored in.
+// Defaults to `DefaultCollectionName`.
+func WithCollection(name string) Option {
+	return func(db *Database) {
+		if name != "" {
+			db.collection = db.Service.Collection(name)
+		}
+	}
+}
+
+// WithTimeout makes every operation of this Database time out after "d" if
+// it hasn't completed by then, including operations started through the
+// non-Ctx methods or with a caller context that has no deadline of its own.
+// It lets a deployment enforce a per-op deadline globally without threading
+// a context through every session call site.
+func WithTimeout(d time.Duration) Option {
+	return func(db *Database) {
+		db.timeout = d
+	}
+}
+
+// New creates and returns a new MongoDB session storage with custom client options.
+// Database name is required.
 //
-// It will remove any old session files.
-func New(clientOpts *options.ClientOptions, database string) (*Database, error) {
+// All sessions are stored in a single collection: entries are keyed by
+// (sid, key) and expire on their own through a TTL index on "expiresAt", so
+// no background cleanup goroutine is required.
+func New(clientOpts *options.ClientOptions, database string, opts ...Option) (*Database, error) {
 	if database == "" {
 		return nil, errDatabaseNameMissing
 	}
@@ -40,8 +95,44 @@ func New(clientOpts *options.ClientOptions, database string) (*Database, error)
 		return nil, err
 	}
 
-	mongo := client.Database(database)
-	return &Database{Service: mongo}, nil
+	mongoDB := client.Database(database)
+	db := &Database{Service: mongoDB, collection: mongoDB.Collection(DefaultCollectionName)}
+	for _, opt := range opts {
+		opt(db)
+	}
+
+	if err = ensureIndexes(ctx, db.collection); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// ensureIndexes creates the compound unique (sid, key) index and the TTL
+// index on "expiresAt" that this package relies on for both lookups and
+// expiration. It is safe to call repeatedly, Mongo is a no-op on an index
+// that already exists with the same keys and options.
+func ensureIndexes(ctx context.Context, coll *mongo.Collection) error {
+	_, err := coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "sid", Value: 1}, {Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+// withTimeout derives a context bound by the Database's configured timeout,
+// if any, from "parent". Callers must always invoke the returned cancel func.
+func (db *Database) withTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	if db.timeout <= 0 {
+		return parent, func() {}
+	}
+	return context.WithTimeout(parent, db.timeout)
 }
 
 // SetLogger sets the logger once before server ran.
@@ -55,65 +146,115 @@ var cookieExpireDelete = time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UT
 // Acquire receives a session's lifetime from the database,
 // if the return value is LifeTime{} then the session manager sets the life time based on the expiration duration lives in configuration.
 func (db *Database) Acquire(sid string, expires time.Duration) sessions.LifeTime {
-	var result bson.Raw
-	ctx := context.TODO()
-	res := db.Service.Collection(sid).FindOne(ctx, bson.D{{Key: "key", Value: sid}})
+	return db.AcquireCtx(context.Background(), sid, expires)
+}
+
+// AcquireCtx is the context-aware variant of `Acquire`.
+func (db *Database) AcquireCtx(ctx context.Context, sid string, expires time.Duration) sessions.LifeTime {
+	if err := ctx.Err(); err != nil {
+		return sessions.LifeTime{}
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
 
-	// not found, create an entry and return an empty lifetime, session manager will do its job.
-	if err := res.Err(); err != nil {
+	var entry sessionEntry
+	err := db.collection.FindOne(ctx, bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: lifetimeKey}}).Decode(&entry)
+	if err != nil {
+		// not found, create an entry and return an empty lifetime, session manager will do its job.
 		expirationTime := time.Now().Add(expires)
-		timeBytes, _ := sessions.DefaultTranscoder.Marshal(expirationTime)
-		timeBase := base64.StdEncoding.EncodeToString(timeBytes)
-		db.Service.Collection(sid).InsertOne(
-			context.TODO(),
-			bson.D{{Key: "$set", Value: bson.D{{Key: "key", Value: sid}, {Key: "value", Value: timeBase}}}},
-		)
+		db.collection.InsertOne(ctx, sessionEntry{Sid: sid, Key: lifetimeKey, ExpiresAt: expirationTime})
 
 		return sessions.LifeTime{Time: cookieExpireDelete}
 	}
 
 	// found, return the expiration.
-	res.Decode(&result)
-	result.Validate()
-	val := result.Lookup("value")
-	var expirationTime time.Time
-	valueBase, _ := base64.StdEncoding.DecodeString(val.StringValue())
-	sessions.DefaultTranscoder.Unmarshal(valueBase, &expirationTime)
-	return sessions.LifeTime{Time: expirationTime}
+	return sessions.LifeTime{Time: entry.ExpiresAt}
 }
 
-// OnUpdateExpiration not implemented here, yet.
-// Note that this error will not be logged, callers should catch it manually.
+// OnUpdateExpiration updates the expiration of all of a session's entries in a
+// single round-trip, it is called when the session's lifetime is renewed.
 func (db *Database) OnUpdateExpiration(sid string, newExpires time.Duration) error {
-	return sessions.ErrNotImplemented
+	return db.OnUpdateExpirationCtx(context.Background(), sid, newExpires)
+}
+
+// OnUpdateExpirationCtx is the context-aware variant of `OnUpdateExpiration`.
+func (db *Database) OnUpdateExpirationCtx(ctx context.Context, sid string, newExpires time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.collection.UpdateMany(ctx,
+		bson.D{{Key: "sid", Value: sid}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "expiresAt", Value: time.Now().Add(newExpires)}}}},
+	)
+	return err
 }
 
 // Set sets a key value of a specific session.
 // Ignore the "immutable".
 func (db *Database) Set(sid string, key string, value interface{}, dur time.Duration, immutable bool) error {
+	return db.SetCtx(context.Background(), sid, key, value, dur, immutable)
+}
+
+// SetCtx is the context-aware variant of `Set`.
+func (db *Database) SetCtx(ctx context.Context, sid string, key string, value interface{}, dur time.Duration, immutable bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	valueBytes, err := sessions.DefaultTranscoder.Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	// convert []byte slice to base64 string
-	valueBase := base64.StdEncoding.EncodeToString(valueBytes)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	expiresAt := db.entryExpiration(ctx, sid, dur)
 
-	_, err = db.Service.Collection(sid).UpdateOne(
-		context.Background(),
+	_, err = db.collection.UpdateOne(
+		ctx,
 		// filter
-		bson.D{{Key: "key", Value: key}},
+		bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: key}},
 		// update
-		bson.D{{Key: "$set", Value: bson.D{{Key: "key", Value: key}, {Key: "value", Value: valueBase}}}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "value", Value: valueBytes}, {Key: "expiresAt", Value: expiresAt}}}},
 		// options
 		options.Update().SetUpsert(true),
 	)
 	return err
 }
 
+// entryExpiration resolves the "expiresAt" every entry of "sid" must carry
+// so the TTL index can reap it: it mirrors the session's lifetime entry's
+// expiration when one exists, so a key written via `Set` always expires
+// alongside the rest of its session, falling back to "dur" (or
+// `defaultEntryExpiration` if "dur" is not positive) for a session whose
+// lifetime entry hasn't been created yet.
+func (db *Database) entryExpiration(ctx context.Context, sid string, dur time.Duration) time.Time {
+	var lifetime sessionEntry
+	if err := db.collection.FindOne(ctx, bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: lifetimeKey}}).Decode(&lifetime); err == nil {
+		return lifetime.ExpiresAt
+	}
+
+	if dur > 0 {
+		return time.Now().Add(dur)
+	}
+
+	return time.Now().Add(defaultEntryExpiration)
+}
+
 // Get retrieves a session value based on the key.
 func (db *Database) Get(sid string, key string) (value interface{}) {
-	if err := db.Decode(sid, key, &value); err == nil {
+	return db.GetCtx(context.Background(), sid, key)
+}
+
+// GetCtx is the context-aware variant of `Get`.
+func (db *Database) GetCtx(ctx context.Context, sid string, key string) (value interface{}) {
+	if err := db.DecodeCtx(ctx, sid, key, &value); err == nil {
 		return value
 	}
 
@@ -122,46 +263,59 @@ func (db *Database) Get(sid string, key string) (value interface{}) {
 
 // Decode binds the "outPtr" to the value associated to the provided "key".
 func (db *Database) Decode(sid, key string, outPtr interface{}) error {
-	var result bson.Raw
-	ctx := context.TODO()
-	res := db.Service.Collection(sid).FindOne(ctx, bson.D{{Key: "key", Value: key}})
+	return db.DecodeCtx(context.Background(), sid, key, outPtr)
+}
 
-	err := res.Decode(&result)
-	if err != nil {
+// DecodeCtx is the context-aware variant of `Decode`.
+func (db *Database) DecodeCtx(ctx context.Context, sid, key string, outPtr interface{}) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	err = result.Validate()
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	var entry sessionEntry
+	err := db.collection.FindOne(ctx, bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: key}}).Decode(&entry)
 	if err != nil {
 		return err
 	}
 
-	val := result.Lookup("value")
-	valueBase, _ := base64.StdEncoding.DecodeString(val.StringValue())
-	sessions.DefaultTranscoder.Unmarshal(valueBase, outPtr)
-	return nil
+	return sessions.DefaultTranscoder.Unmarshal(entry.Value, outPtr)
 }
 
 // Visit loops through all session keys and values.
 func (db *Database) Visit(sid string, cb func(key string, value interface{})) error {
-	ctx := context.TODO()
-	res, err := db.Service.Collection(sid).Find(ctx, bson.D{})
+	return db.VisitCtx(context.Background(), sid, cb)
+}
+
+// VisitCtx is the context-aware variant of `Visit`.
+func (db *Database) VisitCtx(ctx context.Context, sid string, cb func(key string, value interface{})) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.collection.Find(ctx, bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: bson.D{{Key: "$ne", Value: lifetimeKey}}}})
 	if err != nil {
 		return err
 	}
+	defer res.Close(ctx)
 
-	for res.Next(context.TODO()) {
-		var result bson.Raw
-		if err := res.Decode(&result); err != nil {
+	for res.Next(ctx) {
+		var entry sessionEntry
+		if err := res.Decode(&entry); err != nil {
 			return err
 		}
 
-		k := result.Lookup("key")
-		v := result.Lookup("value")
 		var val interface{}
-		valueBase, _ := base64.StdEncoding.DecodeString(v.StringValue())
-		sessions.DefaultTranscoder.Unmarshal(valueBase, &val)
-		cb(k.String(), val)
+		if err := sessions.DefaultTranscoder.Unmarshal(entry.Value, &val); err != nil {
+			return err
+		}
+
+		cb(entry.Key, val)
 	}
 
 	return res.Err()
@@ -169,8 +323,19 @@ func (db *Database) Visit(sid string, cb func(key string, value interface{})) er
 
 // Len returns the length of the session's entries (keys).
 func (db *Database) Len(sid string) (n int) {
-	ctx := context.TODO()
-	number, err := db.Service.Collection(sid).CountDocuments(ctx, bson.D{})
+	return db.LenCtx(context.Background(), sid)
+}
+
+// LenCtx is the context-aware variant of `Len`.
+func (db *Database) LenCtx(ctx context.Context, sid string) (n int) {
+	if err := ctx.Err(); err != nil {
+		return 0
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	number, err := db.collection.CountDocuments(ctx, bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: bson.D{{Key: "$ne", Value: lifetimeKey}}}})
 	if err == nil {
 		n = int(number)
 	}
@@ -180,8 +345,19 @@ func (db *Database) Len(sid string) (n int) {
 
 // Delete removes a session key value based on its key.
 func (db *Database) Delete(sid string, key string) (deleted bool) {
-	ctx := context.TODO()
-	_, err := db.Service.Collection(sid).DeleteOne(ctx, bson.D{{Key: "key", Value: key}})
+	return db.DeleteCtx(context.Background(), sid, key)
+}
+
+// DeleteCtx is the context-aware variant of `Delete`.
+func (db *Database) DeleteCtx(ctx context.Context, sid string, key string) (deleted bool) {
+	if err := ctx.Err(); err != nil {
+		return false
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.collection.DeleteOne(ctx, bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: key}})
 	if err != nil {
 		deleted = false
 		return
@@ -192,18 +368,119 @@ func (db *Database) Delete(sid string, key string) (deleted bool) {
 
 // Clear removes all session key values but it keeps the session entry.
 func (db *Database) Clear(sid string) error {
-	_, err := db.Service.Collection(sid).DeleteMany(context.TODO(), bson.D{{Key: "key", Value: bson.D{{Key: "$ne", Value: sid}}}})
+	return db.ClearCtx(context.Background(), sid)
+}
+
+// ClearCtx is the context-aware variant of `Clear`.
+func (db *Database) ClearCtx(ctx context.Context, sid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.collection.DeleteMany(ctx,
+		bson.D{{Key: "sid", Value: sid}, {Key: "key", Value: bson.D{{Key: "$ne", Value: lifetimeKey}}}})
 	return err
 }
 
 // Release destroys the session, it clears and removes the session entry,
 // session manager will create a new session ID on the next request after this call.
 func (db *Database) Release(sid string) error {
-	return db.Service.Collection(sid).Drop(context.TODO())
+	return db.ReleaseCtx(context.Background(), sid)
 }
 
-// Close terminates Dgraph's gRPC connection.
+// ReleaseCtx is the context-aware variant of `Release`.
+func (db *Database) ReleaseCtx(ctx context.Context, sid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+
+	_, err := db.collection.DeleteMany(ctx, bson.D{{Key: "sid", Value: sid}})
+	return err
+}
+
+// Close terminates the mongo client's connection.
 func (db *Database) Close() error {
 	db.Service.Client().Disconnect(context.TODO())
 	return nil
 }
+
+// MigrateLegacyCollections migrates data written by a pre-v-next version of
+// this package, where every session got its own Mongo collection named after
+// its sid, into the current shared collection. Each legacy collection is
+// dropped once its documents have been rewritten, so existing deployments can
+// upgrade in place by calling this once at startup with the list of
+// known/discovered legacy collection names.
+func (db *Database) MigrateLegacyCollections(ctx context.Context, legacySids ...string) error {
+	for _, sid := range legacySids {
+		legacy := db.Service.Collection(sid)
+
+		cur, err := legacy.Find(ctx, bson.D{})
+		if err != nil {
+			return err
+		}
+
+		var (
+			expiresAt time.Time
+			entries   []sessionEntry
+		)
+
+		for cur.Next(ctx) {
+			var doc bson.Raw
+			if err := cur.Decode(&doc); err != nil {
+				cur.Close(ctx)
+				return err
+			}
+
+			key := doc.Lookup("key").StringValue()
+			valueBase := doc.Lookup("value").StringValue()
+			valueBytes, err := base64.StdEncoding.DecodeString(valueBase)
+			if err != nil {
+				cur.Close(ctx)
+				return err
+			}
+
+			if key == sid {
+				// legacy lifetime entry, its expiration is re-applied to every migrated entry below.
+				if err := sessions.DefaultTranscoder.Unmarshal(valueBytes, &expiresAt); err != nil {
+					cur.Close(ctx)
+					return err
+				}
+				continue
+			}
+
+			entries = append(entries, sessionEntry{Sid: sid, Key: key, Value: valueBytes})
+		}
+		if err := cur.Err(); err != nil {
+			cur.Close(ctx)
+			return err
+		}
+		cur.Close(ctx)
+
+		if expiresAt.IsZero() {
+			expiresAt = time.Now()
+		}
+
+		docs := make([]interface{}, 0, len(entries)+1)
+		docs = append(docs, sessionEntry{Sid: sid, Key: lifetimeKey, ExpiresAt: expiresAt})
+		for _, entry := range entries {
+			entry.ExpiresAt = expiresAt
+			docs = append(docs, entry)
+		}
+
+		if _, err := db.collection.InsertMany(ctx, docs); err != nil {
+			return err
+		}
+
+		if err := legacy.Drop(ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,192 @@
+package mongostore
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12/sessions"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+// TestSetCtxStampsExpiresAt proves that a value written through `Set` always
+// carries a non-zero, future "expiresAt" in its upsert, so the TTL index
+// this package relies on (see the `sessionEntry` doc comment) actually gets
+// a chance to reap it, instead of silently skipping documents that were
+// inserted with the field missing.
+func TestSetCtxStampsExpiresAt(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("set", func(mt *mtest.T) {
+		db := &Database{Service: mt.DB, collection: mt.Coll}
+
+		// the lifetime entry lookup inside entryExpiration finds nothing, so
+		// SetCtx must fall back to "dur" instead of leaving expiresAt unset.
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "sessions.sessions", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateSuccessResponse(
+			bson.E{Key: "n", Value: 1},
+			bson.E{Key: "nModified", Value: 1},
+		))
+
+		if err := db.SetCtx(context.Background(), "sid1", "name", "iris", time.Hour, false); err != nil {
+			t.Fatalf("SetCtx: %v", err)
+		}
+
+		started := mt.GetAllStartedEvents()
+		var update *bson.Raw
+		for _, evt := range started {
+			if evt.CommandName == "update" {
+				cmd := evt.Command
+				update = &cmd
+			}
+		}
+		if update == nil {
+			t.Fatalf("no \"update\" command was observed")
+		}
+
+		updates, err := update.LookupErr("updates")
+		if err != nil {
+			t.Fatalf("updates: %v", err)
+		}
+
+		arr, ok := updates.ArrayOK()
+		if !ok {
+			t.Fatalf("updates is not an array")
+		}
+		elems, err := arr.Elements()
+		if err != nil || len(elems) == 0 {
+			t.Fatalf("updates array is empty: %v", err)
+		}
+
+		first, err := elems[0].Value().Document().LookupErr("u", "$set", "expiresAt")
+		if err != nil {
+			t.Fatalf("$set.expiresAt is missing from the upsert: %v", err)
+		}
+
+		expiresAt := first.Time()
+		if expiresAt.IsZero() {
+			t.Fatalf("expiresAt = zero value, want a non-zero, future time")
+		}
+		if !expiresAt.After(time.Now()) {
+			t.Fatalf("expiresAt = %v, want a time in the future", expiresAt)
+		}
+	})
+}
+
+// TestAcquireCtxCreatesWhenNotFound proves that `AcquireCtx` inserts a new
+// lifetime entry and returns the cookie-delete sentinel when no lifetime
+// entry exists yet for "sid".
+func TestAcquireCtxCreatesWhenNotFound(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("acquire-create", func(mt *mtest.T) {
+		db := &Database{Service: mt.DB, collection: mt.Coll}
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "sessions.sessions", mtest.FirstBatch))
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		lt := db.AcquireCtx(context.Background(), "sid1", time.Hour)
+		if lt.Time != cookieExpireDelete {
+			t.Fatalf("AcquireCtx for an unknown sid = %v, want the cookie-delete sentinel %v", lt.Time, cookieExpireDelete)
+		}
+
+		started := mt.GetAllStartedEvents()
+		var sawInsert bool
+		for _, evt := range started {
+			if evt.CommandName == "insert" {
+				sawInsert = true
+			}
+		}
+		if !sawInsert {
+			t.Fatalf("no \"insert\" command was observed for the new lifetime entry")
+		}
+	})
+}
+
+// TestAcquireCtxReturnsStoredExpirationWhenFound proves that `AcquireCtx`
+// returns the existing lifetime entry's expiration, unmodified, instead of
+// creating a new one, when one is already stored for "sid".
+func TestAcquireCtxReturnsStoredExpirationWhenFound(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("acquire-found", func(mt *mtest.T) {
+		db := &Database{Service: mt.DB, collection: mt.Coll}
+
+		stored := time.Now().Add(2 * time.Hour).Truncate(time.Millisecond)
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "sessions.sessions", mtest.FirstBatch,
+			bson.D{{Key: "sid", Value: "sid1"}, {Key: "key", Value: lifetimeKey}, {Key: "expiresAt", Value: stored}},
+		))
+
+		lt := db.AcquireCtx(context.Background(), "sid1", time.Hour)
+		if !lt.Time.Equal(stored) {
+			t.Fatalf("AcquireCtx = %v, want the stored expiration %v", lt.Time, stored)
+		}
+	})
+}
+
+// TestMigrateLegacyCollectionsHappyPath proves that `MigrateLegacyCollections`
+// reads a legacy per-sid collection's lifetime and entry documents, carries
+// the lifetime's expiration onto every migrated entry, and writes them all
+// into the shared collection.
+func TestMigrateLegacyCollectionsHappyPath(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	defer mt.Close()
+
+	mt.Run("migrate", func(mt *mtest.T) {
+		db := &Database{Service: mt.DB, collection: mt.Coll}
+
+		const sid = "legacy-sid"
+		expiresAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+		expiresBytes, err := sessions.DefaultTranscoder.Marshal(expiresAt)
+		if err != nil {
+			t.Fatalf("marshal expiresAt: %v", err)
+		}
+		expiresBase := base64.StdEncoding.EncodeToString(expiresBytes)
+
+		valueBytes, err := sessions.DefaultTranscoder.Marshal("iris")
+		if err != nil {
+			t.Fatalf("marshal value: %v", err)
+		}
+		valueBase := base64.StdEncoding.EncodeToString(valueBytes)
+
+		// the legacy collection's Find: one lifetime doc (key == sid) and one
+		// real entry, matching what a pre-redesign collection-per-sid store wrote.
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "sessions."+sid, mtest.FirstBatch,
+			bson.D{{Key: "key", Value: sid}, {Key: "value", Value: expiresBase}},
+			bson.D{{Key: "key", Value: "name"}, {Key: "value", Value: valueBase}},
+		))
+		// InsertMany into the shared collection.
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+		// legacy.Drop().
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		if err := db.MigrateLegacyCollections(context.Background(), sid); err != nil {
+			t.Fatalf("MigrateLegacyCollections: %v", err)
+		}
+
+		started := mt.GetAllStartedEvents()
+		var sawInsert, sawDrop bool
+		for _, evt := range started {
+			switch evt.CommandName {
+			case "insert":
+				sawInsert = true
+			case "drop":
+				sawDrop = true
+			}
+		}
+		if !sawInsert {
+			t.Fatalf("no \"insert\" command was observed for the migrated documents")
+		}
+		if !sawDrop {
+			t.Fatalf("the legacy collection was never dropped")
+		}
+	})
+}
@@ -0,0 +1,428 @@
+package multistore
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12/sessions"
+
+	"github.com/kataras/golog"
+)
+
+var errPrimaryMissing = errors.New("primary database is required")
+
+// healthCheckSid is a sid that never corresponds to a real session, used by
+// the background health-check goroutine as a cheap, side-effect-free probe
+// against the primary backend.
+const healthCheckSid = "$multistore-health-check"
+
+// defaultWriteQueueSize is the capacity of the channel writes are enqueued
+// on in `WriteBack` mode, see `WithWriteMode`.
+const defaultWriteQueueSize = 1024
+
+// defaultHealthCheckInterval is how often the primary backend is probed for
+// recovery once it has been marked down.
+const defaultHealthCheckInterval = 10 * time.Second
+
+// WriteMode controls how a `Database` propagates writes to its primary (and
+// cache, if any) backend, see `WithWriteMode`.
+type WriteMode int
+
+const (
+	// WriteThrough writes to the cache and the primary synchronously,
+	// the call blocks until both are done.
+	WriteThrough WriteMode = iota
+	// WriteBack enqueues the write on a bounded channel drained by a
+	// background worker goroutine, the call returns immediately.
+	WriteBack
+)
+
+// Database is a `sessions.Database` that fans out to a primary backend with
+// an optional in-front cache and an optional fallback backend. Reads hit the
+// cache first, miss to the primary (or, if the primary is down, the
+// fallback), then populate the cache. Writes go to the cache and the
+// primary, synchronously or asynchronously depending on the configured
+// `WriteMode`.
+type Database struct {
+	primary     sessions.Database
+	cache       sessions.Database
+	fallback    sessions.Database
+	readThrough bool
+	writeMode   WriteMode
+
+	writeQueue chan func() error
+	stop       context.CancelFunc
+
+	healthCheckInterval time.Duration
+
+	mu          sync.RWMutex
+	primaryDown bool
+
+	logger *golog.Logger
+}
+
+var _ sessions.Database = (*Database)(nil)
+
+// Option configures a Database created by `New`.
+type Option func(*Database)
+
+// WithCache sets an in-process or remote cache that sits in front of the
+// primary backend. Reads try the cache first; on a miss the primary (or
+// fallback) is read and the result is written back into the cache.
+func WithCache(cache sessions.Database) Option {
+	return func(db *Database) {
+		db.cache = cache
+	}
+}
+
+// WithFallback sets a backend to transparently retry against whenever the
+// primary backend returns an error.
+func WithFallback(fallback sessions.Database) Option {
+	return func(db *Database) {
+		db.fallback = fallback
+	}
+}
+
+// WithReadThrough enables or disables reading through the cache set with
+// `WithCache`. Defaults to true; has no effect if no cache was set.
+func WithReadThrough(readThrough bool) Option {
+	return func(db *Database) {
+		db.readThrough = readThrough
+	}
+}
+
+// WithWriteMode sets whether writes are propagated synchronously
+// (`WriteThrough`, the default) or asynchronously (`WriteBack`).
+func WithWriteMode(mode WriteMode) Option {
+	return func(db *Database) {
+		db.writeMode = mode
+	}
+}
+
+// WithHealthCheckInterval overrides how often a downed primary is probed for
+// recovery. Defaults to `defaultHealthCheckInterval`.
+func WithHealthCheckInterval(d time.Duration) Option {
+	return func(db *Database) {
+		db.healthCheckInterval = d
+	}
+}
+
+// New creates and returns a new Database that fans out to "primary" and,
+// depending on the given options, a cache and/or a fallback backend.
+func New(primary sessions.Database, opts ...Option) (*Database, error) {
+	if primary == nil {
+		return nil, errPrimaryMissing
+	}
+
+	db := &Database{
+		primary:             primary,
+		readThrough:         true,
+		writeMode:           WriteThrough,
+		writeQueue:          make(chan func() error, defaultWriteQueueSize),
+		healthCheckInterval: defaultHealthCheckInterval,
+	}
+
+	for _, opt := range opts {
+		opt(db)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.stop = cancel
+
+	go db.runWorker(ctx)
+	go db.runHealthCheck(ctx)
+
+	return db, nil
+}
+
+// SetLogger sets the logger once before server ran.
+// By default the Iris one is injected.
+func (db *Database) SetLogger(logger *golog.Logger) {
+	db.logger = logger
+}
+
+// runWorker drains the write queue until "ctx" is cancelled, used in
+// `WriteBack` mode.
+func (db *Database) runWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case op := <-db.writeQueue:
+			if err := op(); err != nil && db.logger != nil {
+				db.logger.Errorf("multistore: async write failed: %v", err)
+			}
+		}
+	}
+}
+
+// runHealthCheck periodically probes the primary backend and flips it back
+// into rotation once it responds without error again.
+func (db *Database) runHealthCheck(ctx context.Context) {
+	ticker := time.NewTicker(db.healthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !db.isPrimaryDown() {
+				continue
+			}
+
+			if !db.probePrimary() {
+				continue
+			}
+
+			db.setPrimaryDown(false)
+			if db.logger != nil {
+				db.logger.Infof("multistore: primary backend recovered, back in rotation")
+			}
+		}
+	}
+}
+
+// probePrimary reports whether the primary backend should be considered
+// healthy. `OnUpdateExpiration` is used as the probe since every backend
+// implements it, but a backend that legitimately doesn't support renewing a
+// session's expiration may always answer it with `sessions.ErrNotImplemented`
+// instead of actually touching storage - that error is special-cased as
+// "healthy" so such a backend isn't permanently stuck out of rotation once
+// marked down.
+func (db *Database) probePrimary() bool {
+	err := db.primary.OnUpdateExpiration(healthCheckSid, time.Second)
+	return err == nil || errors.Is(err, sessions.ErrNotImplemented)
+}
+
+func (db *Database) isPrimaryDown() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.primaryDown
+}
+
+func (db *Database) setPrimaryDown(down bool) {
+	db.mu.Lock()
+	db.primaryDown = down
+	db.mu.Unlock()
+}
+
+// markPrimaryDown flips the primary out of rotation and logs the error that
+// caused it, once, the first time it happens.
+func (db *Database) markPrimaryDown(err error) {
+	db.mu.Lock()
+	wasDown := db.primaryDown
+	db.primaryDown = true
+	db.mu.Unlock()
+
+	if !wasDown && db.logger != nil {
+		db.logger.Errorf("multistore: primary backend failed, switching to fallback: %v", err)
+	}
+}
+
+// active returns the backend reads/writes should currently prefer: the
+// fallback if the primary is known down and a fallback is configured,
+// otherwise the primary.
+func (db *Database) active() sessions.Database {
+	if db.fallback != nil && db.isPrimaryDown() {
+		return db.fallback
+	}
+	return db.primary
+}
+
+// write runs "op" synchronously in `WriteThrough` mode, or enqueues it to be
+// run by the background worker in `WriteBack` mode.
+func (db *Database) write(op func() error) error {
+	if db.writeMode == WriteBack {
+		select {
+		case db.writeQueue <- op:
+		default:
+			if db.logger != nil {
+				db.logger.Warnf("multistore: write queue is full, dropping a write")
+			}
+		}
+		return nil
+	}
+
+	return op()
+}
+
+// Acquire receives a session's lifetime from the database,
+// if the return value is LifeTime{} then the session manager sets the life time based on the expiration duration lives in configuration.
+func (db *Database) Acquire(sid string, expires time.Duration) sessions.LifeTime {
+	return db.active().Acquire(sid, expires)
+}
+
+// OnUpdateExpiration updates the expiration of a session against the primary,
+// falling back to the fallback backend if the primary is unavailable.
+func (db *Database) OnUpdateExpiration(sid string, newExpires time.Duration) error {
+	return db.write(func() error {
+		err := db.primary.OnUpdateExpiration(sid, newExpires)
+		if err != nil && db.fallback != nil {
+			db.markPrimaryDown(err)
+			if ferr := db.fallback.OnUpdateExpiration(sid, newExpires); ferr == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// Set sets a key value of a specific session, writing to the cache and the
+// primary (or fallback) according to the configured `WriteMode`.
+func (db *Database) Set(sid string, key string, value interface{}, dur time.Duration, immutable bool) error {
+	return db.write(func() error {
+		if db.cache != nil {
+			if err := db.cache.Set(sid, key, value, dur, immutable); err != nil && db.logger != nil {
+				db.logger.Errorf("multistore: cache set: %v", err)
+			}
+		}
+
+		err := db.primary.Set(sid, key, value, dur, immutable)
+		if err != nil && db.fallback != nil {
+			db.markPrimaryDown(err)
+			if ferr := db.fallback.Set(sid, key, value, dur, immutable); ferr == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// Get retrieves a session value based on the key.
+func (db *Database) Get(sid string, key string) (value interface{}) {
+	if err := db.Decode(sid, key, &value); err == nil {
+		return value
+	}
+
+	return nil
+}
+
+// Decode binds the "outPtr" to the value associated to the provided "key".
+// It reads the cache first when read-through is enabled, otherwise (or on a
+// cache miss) it reads the active backend and populates the cache.
+func (db *Database) Decode(sid, key string, outPtr interface{}) error {
+	if db.readThrough && db.cache != nil {
+		if err := db.cache.Decode(sid, key, outPtr); err == nil {
+			return nil
+		}
+	}
+
+	err := db.active().Decode(sid, key, outPtr)
+	if err != nil && db.fallback != nil && db.active() == db.primary {
+		db.markPrimaryDown(err)
+		err = db.fallback.Decode(sid, key, outPtr)
+	}
+
+	if err == nil && db.cache != nil {
+		if cerr := db.cache.Set(sid, key, indirect(outPtr), 0, false); cerr != nil && db.logger != nil {
+			db.logger.Errorf("multistore: cache populate: %v", cerr)
+		}
+	}
+
+	return err
+}
+
+// indirect dereferences the pointer a caller passed to `Decode`, so the
+// decoded value can be handed back to `Set` when populating the cache.
+func indirect(outPtr interface{}) interface{} {
+	return reflect.Indirect(reflect.ValueOf(outPtr)).Interface()
+}
+
+// Visit loops through all session keys and values, it always reads from the
+// active backend directly, the cache may hold only a subset of the keys.
+func (db *Database) Visit(sid string, cb func(key string, value interface{})) error {
+	err := db.active().Visit(sid, cb)
+	if err != nil && db.fallback != nil && db.active() == db.primary {
+		db.markPrimaryDown(err)
+		err = db.fallback.Visit(sid, cb)
+	}
+	return err
+}
+
+// Len returns the length of the session's entries (keys), always from the
+// active backend directly, for the same reason `Visit` does.
+func (db *Database) Len(sid string) (n int) {
+	return db.active().Len(sid)
+}
+
+// Delete removes a session key value based on its key, from the cache and
+// the primary (or fallback). It always runs synchronously, whatever the
+// configured `WriteMode`.
+func (db *Database) Delete(sid string, key string) (deleted bool) {
+	if db.cache != nil {
+		db.cache.Delete(sid, key)
+	}
+
+	deleted = db.primary.Delete(sid, key)
+	if !deleted && db.fallback != nil {
+		deleted = db.fallback.Delete(sid, key)
+	}
+	return deleted
+}
+
+// Clear removes all session key values but it keeps the session entry, on
+// the cache and the primary (or fallback).
+func (db *Database) Clear(sid string) error {
+	return db.write(func() error {
+		if db.cache != nil {
+			if err := db.cache.Clear(sid); err != nil && db.logger != nil {
+				db.logger.Errorf("multistore: cache clear: %v", err)
+			}
+		}
+
+		err := db.primary.Clear(sid)
+		if err != nil && db.fallback != nil {
+			db.markPrimaryDown(err)
+			if ferr := db.fallback.Clear(sid); ferr == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// Release destroys the session, it clears and removes the session entry,
+// session manager will create a new session ID on the next request after this call.
+func (db *Database) Release(sid string) error {
+	return db.write(func() error {
+		if db.cache != nil {
+			if err := db.cache.Release(sid); err != nil && db.logger != nil {
+				db.logger.Errorf("multistore: cache release: %v", err)
+			}
+		}
+
+		err := db.primary.Release(sid)
+		if err != nil && db.fallback != nil {
+			db.markPrimaryDown(err)
+			if ferr := db.fallback.Release(sid); ferr == nil {
+				return nil
+			}
+		}
+		return err
+	})
+}
+
+// Close stops the background worker and health-check goroutines, and closes
+// the primary, cache and fallback backends if they implement `io.Closer`.
+func (db *Database) Close() error {
+	db.stop()
+
+	var firstErr error
+	for _, backend := range []sessions.Database{db.primary, db.cache, db.fallback} {
+		if backend == nil {
+			continue
+		}
+
+		if c, ok := backend.(interface{ Close() error }); ok {
+			if err := c.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
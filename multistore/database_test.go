@@ -0,0 +1,327 @@
+package multistore
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12/sessions"
+
+	"github.com/kataras/golog"
+)
+
+// fakeDatabase is an in-memory `sessions.Database` used to exercise
+// multistore without any real backend. Failing can be toggled to simulate
+// an outage.
+type fakeDatabase struct {
+	mu      sync.Mutex
+	entries map[string]map[string]interface{}
+	failing bool
+	closed  bool
+
+	// onUpdateExpirationErr, when set, is always returned by
+	// OnUpdateExpiration regardless of "failing" - used to simulate a
+	// backend like dgraphstore whose OnUpdateExpiration is simply not
+	// implemented.
+	onUpdateExpirationErr error
+}
+
+func newFakeDatabase() *fakeDatabase {
+	return &fakeDatabase{entries: make(map[string]map[string]interface{})}
+}
+
+func (f *fakeDatabase) setFailing(failing bool) {
+	f.mu.Lock()
+	f.failing = failing
+	f.mu.Unlock()
+}
+
+var errFakeDown = errors.New("fakeDatabase: down")
+
+func (f *fakeDatabase) SetLogger(*golog.Logger) {}
+
+func (f *fakeDatabase) Acquire(sid string, expires time.Duration) sessions.LifeTime {
+	return sessions.LifeTime{}
+}
+
+func (f *fakeDatabase) OnUpdateExpiration(sid string, newExpires time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.onUpdateExpirationErr != nil {
+		return f.onUpdateExpirationErr
+	}
+	if f.failing {
+		return errFakeDown
+	}
+	return nil
+}
+
+func (f *fakeDatabase) Set(sid string, key string, value interface{}, dur time.Duration, immutable bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		return errFakeDown
+	}
+
+	if f.entries[sid] == nil {
+		f.entries[sid] = make(map[string]interface{})
+	}
+	f.entries[sid][key] = value
+	return nil
+}
+
+func (f *fakeDatabase) Get(sid string, key string) (value interface{}) {
+	if err := f.Decode(sid, key, &value); err == nil {
+		return value
+	}
+	return nil
+}
+
+func (f *fakeDatabase) Decode(sid, key string, outPtr interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		return errFakeDown
+	}
+
+	v, ok := f.entries[sid][key]
+	if !ok {
+		return errors.New("fakeDatabase: not found")
+	}
+
+	ptr, ok := outPtr.(*interface{})
+	if !ok {
+		return errors.New("fakeDatabase: only *interface{} outPtr is supported in tests")
+	}
+	*ptr = v
+	return nil
+}
+
+func (f *fakeDatabase) Visit(sid string, cb func(key string, value interface{})) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		return errFakeDown
+	}
+
+	for k, v := range f.entries[sid] {
+		cb(k, v)
+	}
+	return nil
+}
+
+func (f *fakeDatabase) Len(sid string) (n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.entries[sid])
+}
+
+func (f *fakeDatabase) Delete(sid string, key string) (deleted bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.entries[sid][key]; !ok {
+		return false
+	}
+	delete(f.entries[sid], key)
+	return true
+}
+
+func (f *fakeDatabase) Clear(sid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		return errFakeDown
+	}
+	f.entries[sid] = make(map[string]interface{})
+	return nil
+}
+
+func (f *fakeDatabase) Release(sid string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		return errFakeDown
+	}
+	delete(f.entries, sid)
+	return nil
+}
+
+func (f *fakeDatabase) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestDatabaseReadThroughPopulatesCache(t *testing.T) {
+	primary := newFakeDatabase()
+	cache := newFakeDatabase()
+
+	primary.Set("sid1", "name", "iris", 0, false)
+
+	db, err := New(primary, WithCache(cache))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	if got := db.Get("sid1", "name"); got != "iris" {
+		t.Fatalf("Get = %v, want %q", got, "iris")
+	}
+
+	if got := cache.Get("sid1", "name"); got != "iris" {
+		t.Fatalf("cache was not populated after a miss, got %v", got)
+	}
+}
+
+func TestDatabaseWriteThroughWritesBoth(t *testing.T) {
+	primary := newFakeDatabase()
+	cache := newFakeDatabase()
+
+	db, err := New(primary, WithCache(cache), WithWriteMode(WriteThrough))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Set("sid1", "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if got := primary.Get("sid1", "name"); got != "iris" {
+		t.Fatalf("primary = %v, want %q", got, "iris")
+	}
+	if got := cache.Get("sid1", "name"); got != "iris" {
+		t.Fatalf("cache = %v, want %q", got, "iris")
+	}
+}
+
+func TestDatabaseWriteBackIsAsync(t *testing.T) {
+	primary := newFakeDatabase()
+
+	db, err := New(primary, WithWriteMode(WriteBack))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Set("sid1", "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if primary.Get("sid1", "name") == "iris" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("write-back write did not reach the primary in time")
+}
+
+func TestDatabaseFailsOverToFallback(t *testing.T) {
+	primary := newFakeDatabase()
+	fallback := newFakeDatabase()
+	primary.setFailing(true)
+
+	db, err := New(primary, WithFallback(fallback))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Set("sid1", "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set should have failed over to the fallback, got error: %v", err)
+	}
+
+	if got := fallback.Get("sid1", "name"); got != "iris" {
+		t.Fatalf("fallback = %v, want %q", got, "iris")
+	}
+
+	if !db.isPrimaryDown() {
+		t.Fatalf("primary should be marked down after a failed write")
+	}
+}
+
+func TestDatabaseRecoversAfterOutage(t *testing.T) {
+	primary := newFakeDatabase()
+	fallback := newFakeDatabase()
+	primary.setFailing(true)
+
+	db, err := New(primary, WithFallback(fallback), WithHealthCheckInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	if err := db.Set("sid1", "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set should have failed over to the fallback, got error: %v", err)
+	}
+	if !db.isPrimaryDown() {
+		t.Fatalf("primary should be marked down after a failed write")
+	}
+
+	primary.setFailing(false)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if !db.isPrimaryDown() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("primary was not flipped back into rotation after recovering")
+}
+
+func TestDatabaseRecoversWhenPrimaryHealthCheckIsNotImplemented(t *testing.T) {
+	primary := newFakeDatabase()
+	primary.onUpdateExpirationErr = sessions.ErrNotImplemented
+	fallback := newFakeDatabase()
+
+	db, err := New(primary, WithFallback(fallback), WithHealthCheckInterval(5*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	// force the primary down through some unrelated error, e.g. a failed Set.
+	primary.setFailing(true)
+	if err := db.Set("sid1", "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set should have failed over to the fallback, got error: %v", err)
+	}
+	if !db.isPrimaryDown() {
+		t.Fatalf("primary should be marked down after a failed write")
+	}
+	primary.setFailing(false)
+
+	// the health check probes OnUpdateExpiration, which this primary always
+	// answers with ErrNotImplemented - that must still be treated as healthy,
+	// or the primary could never be rotated back in.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if !db.isPrimaryDown() {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatalf("primary was not flipped back into rotation despite OnUpdateExpiration only returning ErrNotImplemented")
+}
+
+func TestDatabaseReadThroughDisabled(t *testing.T) {
+	primary := newFakeDatabase()
+	cache := newFakeDatabase()
+	primary.Set("sid1", "name", "iris", 0, false)
+	cache.Set("sid1", "name", "stale", 0, false)
+
+	db, err := New(primary, WithCache(cache), WithReadThrough(false))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer db.Close()
+
+	if got := db.Get("sid1", "name"); got != "iris" {
+		t.Fatalf("Get with read-through disabled = %v, want the primary's %q", got, "iris")
+	}
+}
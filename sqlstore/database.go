@@ -0,0 +1,354 @@
+package sqlstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kataras/iris/v12/sessions"
+
+	"github.com/kataras/golog"
+	"xorm.io/xorm"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	errEngineMissing = errors.New("engine is required")
+	errEntryNotFound = errors.New("sqlstore: entry not found")
+)
+
+// lifetimeKey is the reserved "key" value used to keep track of a session's
+// expiration time, it never holds an actual caller-set value.
+const lifetimeKey = "$lifetime"
+
+// DefaultPruneInterval is how often the background goroutine started by
+// `New` sweeps expired entries out of the table, unless overridden through
+// `WithPruneInterval`.
+const DefaultPruneInterval = 5 * time.Minute
+
+// defaultEntryExpiration is the fallback TTL stamped on an entry written
+// through `Set` when the caller gave no duration and the session has no
+// lifetime entry yet to copy an expiration from.
+const defaultEntryExpiration = 24 * time.Hour
+
+// SessionEntry is the xorm-mapped row for a single session key-value pair.
+// Entries sharing the same "Sid" belong to the same session; the special
+// "$lifetime" key tracks that session's expiration.
+type SessionEntry struct {
+	ID        int64  `xorm:"pk autoincr"`
+	Sid       string `xorm:"varchar(255) notnull unique(sid_key) index 'sid'"`
+	Key       string `xorm:"varchar(255) notnull unique(sid_key) 'key'"`
+	Value     []byte `xorm:"blob 'value'"`
+	ExpiresAt int64  `xorm:"notnull index 'expires_at'"`
+}
+
+// Database the xorm-backed SQL session storage, supports any driver xorm
+// supports, see `NewSQLite`, `NewMySQL` and `NewPostgres` for the common ones.
+type Database struct {
+	// Engine is the underlying xorm engine, exposed for advanced use cases.
+	Engine *xorm.Engine
+
+	pruneInterval time.Duration
+	cancelPrune   context.CancelFunc
+	logger        *golog.Logger
+}
+
+var _ sessions.Database = (*Database)(nil)
+
+// Option configures a Database created by `New`.
+type Option func(*Database)
+
+// WithPruneInterval overrides how often expired entries are swept from the
+// table by the background goroutine started by `New`. Defaults to
+// `DefaultPruneInterval`.
+func WithPruneInterval(d time.Duration) Option {
+	return func(db *Database) {
+		db.pruneInterval = d
+	}
+}
+
+// New creates and returns a new SQL session storage on top of the given xorm
+// engine. It syncs the `SessionEntry` table and starts a background
+// goroutine that periodically deletes expired rows; call `Close` to stop it.
+func New(engine *xorm.Engine, opts ...Option) (*Database, error) {
+	if engine == nil {
+		return nil, errEngineMissing
+	}
+
+	if err := engine.Sync2(new(SessionEntry)); err != nil {
+		return nil, err
+	}
+
+	db := &Database{Engine: engine, pruneInterval: DefaultPruneInterval}
+	for _, opt := range opts {
+		opt(db)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	db.cancelPrune = cancel
+	go db.prune(ctx)
+
+	return db, nil
+}
+
+// NewSQLite creates and returns a new SQL session storage backed by a SQLite
+// database file at "path". Use ":memory:" for an in-memory, hermetic store.
+func NewSQLite(path string) (*Database, error) {
+	engine, err := xorm.NewEngine("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == ":memory:" {
+		// every new connection to ":memory:" opens a separate, empty database,
+		// keep a single one so all callers share the synced table.
+		engine.SetMaxOpenConns(1)
+	}
+
+	return New(engine)
+}
+
+// NewMySQL creates and returns a new SQL session storage backed by MySQL,
+// "dsn" follows the go-sql-driver/mysql DSN format.
+func NewMySQL(dsn string) (*Database, error) {
+	engine, err := xorm.NewEngine("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(engine)
+}
+
+// NewPostgres creates and returns a new SQL session storage backed by
+// Postgres, "dsn" follows the lib/pq connection string format.
+func NewPostgres(dsn string) (*Database, error) {
+	engine, err := xorm.NewEngine("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(engine)
+}
+
+// prune periodically deletes expired entries until "ctx" is cancelled.
+func (db *Database) prune(ctx context.Context) {
+	ticker := time.NewTicker(db.pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := db.Engine.Where("expires_at < ?", time.Now().Unix()).Delete(new(SessionEntry)); err != nil && db.logger != nil {
+				db.logger.Debugf("sqlstore: prune: %v", err)
+			}
+		}
+	}
+}
+
+// SetLogger sets the logger once before server ran.
+// By default the Iris one is injected.
+func (db *Database) SetLogger(logger *golog.Logger) {
+	db.logger = logger
+}
+
+var cookieExpireDelete = time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+// insertIfAbsentSQL and upsertSQL build the dialect-specific atomic upsert
+// statements this package relies on instead of a non-atomic Get-then-
+// Insert/Update, which would race against the unique (sid, key) constraint
+// under concurrent access to the same session. The MySQL variant of
+// `insertIfAbsentSQL` updates a column to itself on conflict, a no-op that
+// still reports zero affected rows, so callers can use the same
+// "affected == 1 means created" check across all three dialects.
+func insertIfAbsentSQL(table, driver string) string {
+	if driver == "mysql" {
+		return fmt.Sprintf("INSERT INTO %s (sid, `key`, value, expires_at) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE sid = sid", table)
+	}
+	return fmt.Sprintf(`INSERT INTO %s (sid, "key", value, expires_at) VALUES (?, ?, ?, ?) ON CONFLICT (sid, "key") DO NOTHING`, table)
+}
+
+func upsertSQL(table, driver string) string {
+	if driver == "mysql" {
+		return fmt.Sprintf("INSERT INTO %s (sid, `key`, value, expires_at) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)", table)
+	}
+	return fmt.Sprintf(`INSERT INTO %s (sid, "key", value, expires_at) VALUES (?, ?, ?, ?) ON CONFLICT (sid, "key") DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`, table)
+}
+
+// insertEntryIfAbsent atomically creates (sid, key) with "value" and
+// "expiresAt" unless it already exists, reporting whether this call is the
+// one that created it.
+func (db *Database) insertEntryIfAbsent(sid, key string, value []byte, expiresAt int64) (created bool, err error) {
+	table := db.Engine.TableName(new(SessionEntry), true)
+	res, err := db.Engine.Exec(insertIfAbsentSQL(table, db.Engine.DriverName()), sid, key, value, expiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Acquire receives a session's lifetime from the database,
+// if the return value is LifeTime{} then the session manager sets the life time based on the expiration duration lives in configuration.
+func (db *Database) Acquire(sid string, expires time.Duration) sessions.LifeTime {
+	var entry SessionEntry
+	has, err := db.Engine.Where("sid = ? AND `key` = ?", sid, lifetimeKey).Get(&entry)
+	if err == nil && has {
+		// found, return the expiration.
+		return sessions.LifeTime{Time: time.Unix(entry.ExpiresAt, 0)}
+	}
+
+	// not found: atomically create the lifetime entry, so a concurrent
+	// Acquire for the same brand new sid can't also insert and collide on
+	// the unique (sid, key) constraint.
+	expirationTime := time.Now().Add(expires)
+	created, err := db.insertEntryIfAbsent(sid, lifetimeKey, nil, expirationTime.Unix())
+	if err != nil {
+		return sessions.LifeTime{Time: cookieExpireDelete}
+	}
+	if created {
+		return sessions.LifeTime{Time: cookieExpireDelete}
+	}
+
+	// lost the race, another goroutine created it first: read back what it stored.
+	has, err = db.Engine.Where("sid = ? AND `key` = ?", sid, lifetimeKey).Get(&entry)
+	if err != nil || !has {
+		return sessions.LifeTime{Time: cookieExpireDelete}
+	}
+	return sessions.LifeTime{Time: time.Unix(entry.ExpiresAt, 0)}
+}
+
+// OnUpdateExpiration updates the expiration of all of a session's entries,
+// it is called when the session's lifetime is renewed.
+func (db *Database) OnUpdateExpiration(sid string, newExpires time.Duration) error {
+	_, err := db.Engine.Where("sid = ?", sid).Cols("expires_at").Update(&SessionEntry{ExpiresAt: time.Now().Add(newExpires).Unix()})
+	return err
+}
+
+// Set sets a key value of a specific session.
+// Ignore the "immutable".
+func (db *Database) Set(sid string, key string, value interface{}, dur time.Duration, immutable bool) error {
+	valueBytes, err := sessions.DefaultTranscoder.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	// an atomic upsert, so a concurrent write to the same (sid, key) updates
+	// the existing row instead of racing it against the unique constraint.
+	table := db.Engine.TableName(new(SessionEntry), true)
+	expiresAt := db.entryExpiration(sid, dur)
+	_, err = db.Engine.Exec(upsertSQL(table, db.Engine.DriverName()), sid, key, valueBytes, expiresAt)
+	return err
+}
+
+// entryExpiration resolves the "expires_at" a key entry written through
+// `Set` must carry so the prune goroutine never reaps it ahead of its
+// session: it mirrors the session's lifetime entry's expiration when one
+// exists, so a key always expires alongside the rest of its session,
+// falling back to "dur" (or `defaultEntryExpiration` if "dur" is not
+// positive) for a session whose lifetime entry hasn't been created yet.
+func (db *Database) entryExpiration(sid string, dur time.Duration) int64 {
+	var lifetime SessionEntry
+	has, err := db.Engine.Where("sid = ? AND `key` = ?", sid, lifetimeKey).Get(&lifetime)
+	if err == nil && has {
+		return lifetime.ExpiresAt
+	}
+
+	if dur > 0 {
+		return time.Now().Add(dur).Unix()
+	}
+
+	return time.Now().Add(defaultEntryExpiration).Unix()
+}
+
+// Get retrieves a session value based on the key.
+func (db *Database) Get(sid string, key string) (value interface{}) {
+	if err := db.Decode(sid, key, &value); err == nil {
+		return value
+	}
+
+	return nil
+}
+
+// Decode binds the "outPtr" to the value associated to the provided "key".
+func (db *Database) Decode(sid, key string, outPtr interface{}) error {
+	var entry SessionEntry
+	has, err := db.Engine.Where("sid = ? AND `key` = ?", sid, key).Get(&entry)
+	if err != nil {
+		return err
+	}
+
+	if !has {
+		return errEntryNotFound
+	}
+
+	return sessions.DefaultTranscoder.Unmarshal(entry.Value, outPtr)
+}
+
+// Visit loops through all session keys and values.
+func (db *Database) Visit(sid string, cb func(key string, value interface{})) error {
+	var entries []SessionEntry
+	if err := db.Engine.Where("sid = ? AND `key` <> ?", sid, lifetimeKey).Find(&entries); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		var val interface{}
+		if err := sessions.DefaultTranscoder.Unmarshal(entry.Value, &val); err != nil {
+			return err
+		}
+
+		cb(entry.Key, val)
+	}
+
+	return nil
+}
+
+// Len returns the length of the session's entries (keys).
+func (db *Database) Len(sid string) (n int) {
+	number, err := db.Engine.Where("sid = ? AND `key` <> ?", sid, lifetimeKey).Count(new(SessionEntry))
+	if err == nil {
+		n = int(number)
+	}
+
+	return
+}
+
+// Delete removes a session key value based on its key.
+func (db *Database) Delete(sid string, key string) (deleted bool) {
+	affected, err := db.Engine.Where("sid = ? AND `key` = ?", sid, key).Delete(new(SessionEntry))
+	if err != nil {
+		return false
+	}
+
+	return affected > 0
+}
+
+// Clear removes all session key values but it keeps the session entry.
+func (db *Database) Clear(sid string) error {
+	_, err := db.Engine.Where("sid = ? AND `key` <> ?", sid, lifetimeKey).Delete(new(SessionEntry))
+	return err
+}
+
+// Release destroys the session, it clears and removes the session entry,
+// session manager will create a new session ID on the next request after this call.
+func (db *Database) Release(sid string) error {
+	_, err := db.Engine.Where("sid = ?", sid).Delete(new(SessionEntry))
+	return err
+}
+
+// Close stops the background pruning goroutine and closes the underlying
+// xorm engine.
+func (db *Database) Close() error {
+	db.cancelPrune()
+	return db.Engine.Close()
+}
@@ -0,0 +1,230 @@
+package sqlstore
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kataras/iris/v12/sessions"
+)
+
+// newTestDatabase returns a Database backed by a hermetic in-memory SQLite
+// engine, fresh for every test.
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+
+	db, err := NewSQLite(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func TestDatabaseSetGetDelete(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid1"
+
+	if err := db.Set(sid, "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	var got string
+	if err := db.Decode(sid, "name", &got); err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got != "iris" {
+		t.Fatalf("Decode = %q, want %q", got, "iris")
+	}
+
+	if n := db.Len(sid); n != 1 {
+		t.Fatalf("Len = %d, want 1", n)
+	}
+
+	if !db.Delete(sid, "name") {
+		t.Fatalf("Delete returned false")
+	}
+
+	if n := db.Len(sid); n != 0 {
+		t.Fatalf("Len after delete = %d, want 0", n)
+	}
+}
+
+func TestDatabaseAcquireAndOnUpdateExpiration(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid2"
+
+	lt := db.Acquire(sid, time.Hour)
+	if lt.Time != cookieExpireDelete {
+		t.Fatalf("first Acquire should return the cookie-delete sentinel, got %v", lt.Time)
+	}
+
+	lt = db.Acquire(sid, time.Hour)
+	if lt.Time.IsZero() || lt.Time == cookieExpireDelete {
+		t.Fatalf("second Acquire should return the stored expiration, got %v", lt.Time)
+	}
+
+	if err := db.OnUpdateExpiration(sid, 2*time.Hour); err != nil {
+		t.Fatalf("OnUpdateExpiration: %v", err)
+	}
+
+	updated := db.Acquire(sid, time.Hour)
+	if !updated.Time.After(lt.Time) {
+		t.Fatalf("expiration was not renewed: got %v, want after %v", updated.Time, lt.Time)
+	}
+}
+
+func TestDatabaseClearKeepsSessionEntry(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid3"
+
+	db.Acquire(sid, time.Hour)
+	db.Set(sid, "a", 1, 0, false)
+	db.Set(sid, "b", 2, 0, false)
+
+	if err := db.Clear(sid); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+
+	if n := db.Len(sid); n != 0 {
+		t.Fatalf("Len after Clear = %d, want 0", n)
+	}
+
+	// the lifetime entry must survive a Clear.
+	lt := db.Acquire(sid, time.Hour)
+	if lt.Time == cookieExpireDelete {
+		t.Fatalf("Clear must not remove the session's lifetime entry")
+	}
+}
+
+func TestDatabaseAcquireConcurrentIsRaceFree(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid5"
+	const goroutines = 16
+
+	var wg sync.WaitGroup
+	results := make([]sessions.LifeTime, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = db.Acquire(sid, time.Hour)
+		}(i)
+	}
+	wg.Wait()
+
+	// exactly one goroutine must have created the entry (cookie-delete
+	// sentinel), everyone else must observe the same stored expiration.
+	var created int
+	var stored time.Time
+	for _, lt := range results {
+		if lt.Time == cookieExpireDelete {
+			created++
+			continue
+		}
+		if stored.IsZero() {
+			stored = lt.Time
+		} else if !lt.Time.Equal(stored) {
+			t.Fatalf("concurrent Acquire calls observed different stored expirations: %v vs %v", stored, lt.Time)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("exactly one Acquire should have created the entry, got %d", created)
+	}
+
+	if n := db.Len(sid); n != 0 {
+		t.Fatalf("Len = %d, want 0 (only the lifetime entry should exist)", n)
+	}
+}
+
+func TestDatabaseSetConcurrentDoesNotError(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid6"
+	const goroutines = 16
+
+	var wg sync.WaitGroup
+	errs := make([]error, goroutines)
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = db.Set(sid, "name", i, 0, false)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("Set #%d: %v", i, err)
+		}
+	}
+
+	if n := db.Len(sid); n != 1 {
+		t.Fatalf("Len = %d, want 1 (concurrent Set calls must upsert the same key)", n)
+	}
+}
+
+func TestDatabaseSetSurvivesPrune(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid7"
+
+	// give the session a lifetime far in the future, the way a live session
+	// manager would before ever calling Set.
+	db.Acquire(sid, time.Hour)
+	if err := db.Set(sid, "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	// force a prune pass using the exact same query the background
+	// goroutine runs, instead of waiting out DefaultPruneInterval.
+	if _, err := db.Engine.Where("expires_at < ?", time.Now().Unix()).Delete(new(SessionEntry)); err != nil {
+		t.Fatalf("prune query: %v", err)
+	}
+
+	var got string
+	if err := db.Decode(sid, "name", &got); err != nil {
+		t.Fatalf("Decode after prune: %v, value must survive prune while its session is still alive", err)
+	}
+	if got != "iris" {
+		t.Fatalf("Decode after prune = %q, want %q", got, "iris")
+	}
+}
+
+func TestDatabaseSetWithoutLifetimeEntryGetsAFutureExpiration(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid8"
+
+	// Set before Acquire: no lifetime entry exists yet, Set must still fall
+	// back to a sane future expiration instead of the zero value.
+	if err := db.Set(sid, "name", "iris", 0, false); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	var entry SessionEntry
+	has, err := db.Engine.Where("sid = ? AND `key` = ?", sid, "name").Get(&entry)
+	if err != nil || !has {
+		t.Fatalf("entry not found after Set: has=%v err=%v", has, err)
+	}
+
+	if entry.ExpiresAt <= time.Now().Unix() {
+		t.Fatalf("ExpiresAt = %v, want a time in the future", time.Unix(entry.ExpiresAt, 0))
+	}
+}
+
+func TestDatabaseRelease(t *testing.T) {
+	db := newTestDatabase(t)
+	const sid = "sid4"
+
+	db.Acquire(sid, time.Hour)
+	db.Set(sid, "a", 1, 0, false)
+
+	if err := db.Release(sid); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+
+	lt := db.Acquire(sid, time.Hour)
+	if lt.Time != cookieExpireDelete {
+		t.Fatalf("Acquire after Release should behave like a brand new session, got %v", lt.Time)
+	}
+}